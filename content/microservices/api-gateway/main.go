@@ -1,18 +1,829 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
 type Response struct {
-	Service string      `json:"service"`
-	Data    interface{} `json:"data"`
-	Error   string      `json:"error,omitempty"`
+	Service  string      `json:"service"`
+	Data     interface{} `json:"data"`
+	Error    string      `json:"error,omitempty"`
+	Attempts int         `json:"attempts,omitempty"`
+}
+
+// RetryPolicy controls how proxyRequest retries a failed upstream call.
+type RetryPolicy struct {
+	MaxAttempts       int
+	InitialDelay      time.Duration
+	MaxDelay          time.Duration
+	BackoffMultiplier float64
+	RetryableStatus   map[int]bool
+}
+
+// defaultRetryPolicy is used when no env overrides are set.
+func defaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:       3,
+		InitialDelay:      100 * time.Millisecond,
+		MaxDelay:          2 * time.Second,
+		BackoffMultiplier: 2.0,
+		RetryableStatus: map[int]bool{
+			http.StatusInternalServerError: true,
+			http.StatusBadGateway:          true,
+			http.StatusServiceUnavailable:  true,
+			http.StatusGatewayTimeout:      true,
+		},
+	}
+}
+
+// retryPolicyFromEnv builds a RetryPolicy from RETRY_* env vars, falling back
+// to defaultRetryPolicy for anything unset or invalid.
+func retryPolicyFromEnv() RetryPolicy {
+	policy := defaultRetryPolicy()
+
+	if v := os.Getenv("RETRY_MAX_ATTEMPTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			policy.MaxAttempts = n
+		}
+	}
+
+	if v := os.Getenv("RETRY_BASE_DELAY_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			policy.InitialDelay = time.Duration(n) * time.Millisecond
+		}
+	}
+
+	if v := os.Getenv("RETRY_MAX_DELAY_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			policy.MaxDelay = time.Duration(n) * time.Millisecond
+		}
+	}
+
+	if v := os.Getenv("RETRY_BACKOFF_MULTIPLIER"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 1 {
+			policy.BackoffMultiplier = f
+		}
+	}
+
+	return policy
+}
+
+// backoffDelay returns the delay to wait after the given failed attempt
+// (0-indexed, so 0 gives the wait before the first retry), applying the
+// policy's multiplier, cap, and up to 50% jitter.
+func backoffDelay(policy RetryPolicy, attempt int) time.Duration {
+	delay := float64(policy.InitialDelay)
+	for i := 0; i < attempt; i++ {
+		delay *= policy.BackoffMultiplier
+	}
+
+	if capped := float64(policy.MaxDelay); delay > capped {
+		delay = capped
+	}
+
+	jitter := delay * 0.5 * rand.Float64()
+	return time.Duration(delay + jitter)
+}
+
+var retryPolicy = retryPolicyFromEnv()
+
+// CircuitState is one of the three states a CircuitBreaker can be in.
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// CircuitBreakerConfig controls the failure threshold, rolling window, and
+// cool-off period shared by every CircuitBreaker.
+type CircuitBreakerConfig struct {
+	FailureThreshold int
+	Window           time.Duration
+	CoolOff          time.Duration
+}
+
+// circuitBreakerConfigFromEnv builds a CircuitBreakerConfig from CB_* env
+// vars, falling back to sane defaults for anything unset or invalid.
+func circuitBreakerConfigFromEnv() CircuitBreakerConfig {
+	cfg := CircuitBreakerConfig{
+		FailureThreshold: 5,
+		Window:           30 * time.Second,
+		CoolOff:          15 * time.Second,
+	}
+
+	if v := os.Getenv("CB_FAILURE_THRESHOLD"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.FailureThreshold = n
+		}
+	}
+
+	if v := os.Getenv("CB_WINDOW_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Window = time.Duration(n) * time.Second
+		}
+	}
+
+	if v := os.Getenv("CB_COOLOFF_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.CoolOff = time.Duration(n) * time.Second
+		}
+	}
+
+	return cfg
+}
+
+// CircuitBreaker tracks rolling-window failures for a single upstream and
+// decides whether calls to it should be allowed through.
+type CircuitBreaker struct {
+	cfg CircuitBreakerConfig
+
+	mu            sync.Mutex
+	state         CircuitState
+	failures      []time.Time
+	openedAt      time.Time
+	probeInFlight bool
+}
+
+func newCircuitBreaker(cfg CircuitBreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{cfg: cfg, state: CircuitClosed}
+}
+
+// Allow reports whether a request may proceed to the upstream. It also
+// transitions Open -> HalfOpen once the cool-off has elapsed, admitting a
+// single probe request.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.cfg.CoolOff {
+			return false
+		}
+		cb.state = CircuitHalfOpen
+		cb.probeInFlight = true
+		return true
+	case CircuitHalfOpen:
+		return !cb.probeInFlight
+	default:
+		return true
+	}
+}
+
+// RecordSuccess closes the breaker (from Closed or HalfOpen).
+func (cb *CircuitBreaker) RecordSuccess() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.state = CircuitClosed
+	cb.failures = nil
+	cb.probeInFlight = false
+}
+
+// RecordFailure records a failure and trips the breaker when the failures in
+// the rolling window reach the configured threshold, or immediately re-opens it if
+// the half-open probe failed.
+func (cb *CircuitBreaker) RecordFailure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitHalfOpen {
+		cb.probeInFlight = false
+		cb.trip()
+		return
+	}
+
+	now := time.Now()
+	cb.failures = append(cb.failures, now)
+
+	cutoff := now.Add(-cb.cfg.Window)
+	kept := cb.failures[:0]
+	for _, t := range cb.failures {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	cb.failures = kept
+
+	if len(cb.failures) >= cb.cfg.FailureThreshold {
+		cb.trip()
+	}
+}
+
+// trip must be called with cb.mu held.
+func (cb *CircuitBreaker) trip() {
+	cb.state = CircuitOpen
+	cb.openedAt = time.Now()
+	cb.failures = nil
+}
+
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+var (
+	circuitBreakerConfig = circuitBreakerConfigFromEnv()
+
+	breakersMu sync.Mutex
+	breakers   = map[string]*CircuitBreaker{}
+)
+
+// breakerFor returns the CircuitBreaker for serviceName, creating one on
+// first use.
+func breakerFor(serviceName string) *CircuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+
+	cb, ok := breakers[serviceName]
+	if !ok {
+		cb = newCircuitBreaker(circuitBreakerConfig)
+		breakers[serviceName] = cb
+	}
+	return cb
+}
+
+// requestIDContextKey is the context key under which the current request's
+// X-Request-ID is stored.
+type requestIDContextKey struct{}
+
+// generateRequestID returns a random 16-character hex string, falling back
+// to a timestamp if the system CSPRNG is unavailable.
+func generateRequestID() string {
+	var buf [8]byte
+	if _, err := cryptorand.Read(buf[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// requestIDFromContext returns the request ID stashed by withRequestID, or
+// "" if none is present.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// withRequestID ensures every request carries an X-Request-ID, generating
+// one when the caller didn't send it, and propagates it on the response and
+// through the request context so gateway and upstream logs can be
+// correlated.
+func withRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqID := r.Header.Get("X-Request-ID")
+		if reqID == "" {
+			reqID = generateRequestID()
+			r.Header.Set("X-Request-ID", reqID)
+		}
+		w.Header().Set("X-Request-ID", reqID)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey{}, reqID)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// histogramBuckets are the upper bounds (seconds) used for
+// gateway_request_duration_seconds, matching Prometheus's default buckets.
+var histogramBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type durationHistogram struct {
+	buckets []int64 // cumulative count of observations <= histogramBuckets[i]
+	sum     float64
+	count   int64
+}
+
+func observeDuration(service string, seconds float64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	h, ok := durations[service]
+	if !ok {
+		h = &durationHistogram{buckets: make([]int64, len(histogramBuckets))}
+		durations[service] = h
+	}
+
+	h.sum += seconds
+	h.count++
+	for i, bound := range histogramBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+}
+
+type requestCounterKey struct {
+	service, method, status string
+}
+
+type errorCounterKey struct {
+	service, kind string
+}
+
+var (
+	metricsMu        sync.Mutex
+	requestsTotal    = map[requestCounterKey]int64{}
+	upstreamErrors   = map[errorCounterKey]int64{}
+	durations        = map[string]*durationHistogram{}
+	inFlightRequests int64 // accessed via sync/atomic
+)
+
+func recordRequest(service, method string, status int) {
+	metricsMu.Lock()
+	requestsTotal[requestCounterKey{service, method, strconv.Itoa(status)}]++
+	metricsMu.Unlock()
+}
+
+func recordUpstreamError(service, kind string) {
+	metricsMu.Lock()
+	upstreamErrors[errorCounterKey{service, kind}]++
+	metricsMu.Unlock()
+}
+
+func circuitStateValue(s CircuitState) int {
+	switch s {
+	case CircuitHalfOpen:
+		return 1
+	case CircuitOpen:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// handleMetrics exposes gateway_* metrics in the Prometheus text exposition
+// format.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP gateway_requests_total Total proxied requests.\n")
+	b.WriteString("# TYPE gateway_requests_total counter\n")
+	for k, v := range requestsTotal {
+		fmt.Fprintf(&b, "gateway_requests_total{service=%q,method=%q,status=%q} %d\n", k.service, k.method, k.status, v)
+	}
+
+	b.WriteString("# HELP gateway_request_duration_seconds Upstream request latency.\n")
+	b.WriteString("# TYPE gateway_request_duration_seconds histogram\n")
+	for service, h := range durations {
+		for i, bound := range histogramBuckets {
+			fmt.Fprintf(&b, "gateway_request_duration_seconds_bucket{service=%q,le=%q} %d\n", service, strconv.FormatFloat(bound, 'g', -1, 64), h.buckets[i])
+		}
+		fmt.Fprintf(&b, "gateway_request_duration_seconds_bucket{service=%q,le=\"+Inf\"} %d\n", service, h.count)
+		fmt.Fprintf(&b, "gateway_request_duration_seconds_sum{service=%q} %g\n", service, h.sum)
+		fmt.Fprintf(&b, "gateway_request_duration_seconds_count{service=%q} %d\n", service, h.count)
+	}
+
+	b.WriteString("# HELP gateway_upstream_errors_total Upstream errors by kind.\n")
+	b.WriteString("# TYPE gateway_upstream_errors_total counter\n")
+	for k, v := range upstreamErrors {
+		fmt.Fprintf(&b, "gateway_upstream_errors_total{service=%q,kind=%q} %d\n", k.service, k.kind, v)
+	}
+
+	b.WriteString("# HELP gateway_in_flight_requests Requests currently being proxied.\n")
+	b.WriteString("# TYPE gateway_in_flight_requests gauge\n")
+	fmt.Fprintf(&b, "gateway_in_flight_requests %d\n", atomic.LoadInt64(&inFlightRequests))
+
+	b.WriteString("# HELP gateway_circuit_breaker_state Circuit breaker state (0=closed,1=half-open,2=open).\n")
+	b.WriteString("# TYPE gateway_circuit_breaker_state gauge\n")
+	breakersMu.Lock()
+	for name, cb := range breakers {
+		fmt.Fprintf(&b, "gateway_circuit_breaker_state{service=%q} %d\n", name, circuitStateValue(cb.State()))
+	}
+	breakersMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+// Route proxy modes. RouteModeTransparent streams the request straight
+// through via httputil.ReverseProxy; RouteModeEnvelope buffers the upstream
+// response and wraps it in the legacy JSON Response envelope.
+const (
+	RouteModeTransparent = "transparent"
+	RouteModeEnvelope    = "envelope"
+)
+
+// Route describes a single upstream registered with the gateway: requests
+// whose path starts with Prefix are proxied to UpstreamURL with Prefix
+// stripped from the forwarded path. Mode selects transparent streaming or
+// the JSON-envelope mode (see effectiveMode for the default), and envelope
+// mode can also be opted into per-request via the wrap=true query param.
+// Methods, if non-empty, restricts which HTTP methods are proxied at all;
+// anything else gets a 405 without dialing upstream.
+type Route struct {
+	Name        string   `json:"name"`
+	Prefix      string   `json:"prefix"`
+	UpstreamURL string   `json:"upstreamURL"`
+	HealthPath  string   `json:"healthPath,omitempty"`
+	Mode        string   `json:"mode,omitempty"`
+	Methods     []string `json:"methods,omitempty"`
+}
+
+// effectiveMode returns route's configured proxy mode, defaulting to
+// RouteModeTransparent when Mode is unset.
+func effectiveMode(route Route) string {
+	if route.Mode == "" {
+		return RouteModeTransparent
+	}
+	return route.Mode
+}
+
+// allowsMethod reports whether method may be proxied for route. An empty
+// Methods list allows every method.
+func (route Route) allowsMethod(method string) bool {
+	if len(route.Methods) == 0 {
+		return true
+	}
+	for _, m := range route.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteRegistry holds the active set of routes and supports atomic
+// replacement so a SIGHUP reload never races a request in flight.
+type RouteRegistry struct {
+	mu     sync.RWMutex
+	routes []Route
+}
+
+func newRouteRegistry(routes []Route) *RouteRegistry {
+	reg := &RouteRegistry{}
+	reg.Reload(routes)
+	return reg
+}
+
+// Reload replaces the active routes, ordering them longest-prefix-first so
+// Match always picks the most specific route.
+func (reg *RouteRegistry) Reload(routes []Route) {
+	sorted := make([]Route, len(routes))
+	copy(sorted, routes)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i].Prefix) > len(sorted[j].Prefix)
+	})
+
+	reg.mu.Lock()
+	reg.routes = sorted
+	reg.mu.Unlock()
+}
+
+// Match returns the most specific route whose prefix matches path.
+func (reg *RouteRegistry) Match(path string) (Route, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	for _, rt := range reg.routes {
+		if strings.HasPrefix(path, rt.Prefix) {
+			return rt, true
+		}
+	}
+	return Route{}, false
+}
+
+// Snapshot returns a copy of the active routes, safe to serialize or range
+// over without holding the registry's lock.
+func (reg *RouteRegistry) Snapshot() []Route {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	out := make([]Route, len(reg.routes))
+	copy(out, reg.routes)
+	return out
+}
+
+var routeRegistry *RouteRegistry
+
+// defaultRoutes is used when GATEWAY_ROUTES_FILE is not set, preserving the
+// gateway's original hello-service/counter-service wiring: both upstreams
+// stay on the buffered JSON-envelope path (retries, circuit breaker, typed
+// errors, Attempts), "/counter" still targets the upstream's "/count"
+// endpoint rather than its root, and "/counter/increment" is still
+// POST-only. Transparent streaming only takes over for routes registered
+// via GATEWAY_ROUTES_FILE that don't opt into envelope mode.
+func defaultRoutes(helloServiceURL, counterServiceURL string) []Route {
+	return []Route{
+		{
+			Name:        "hello-service",
+			Prefix:      "/hello",
+			UpstreamURL: helloServiceURL,
+			HealthPath:  "/health",
+			Mode:        RouteModeEnvelope,
+		},
+		{
+			Name:        "counter-service",
+			Prefix:      "/counter/increment",
+			UpstreamURL: counterServiceURL + "/increment",
+			HealthPath:  "/health",
+			Mode:        RouteModeEnvelope,
+			Methods:     []string{http.MethodPost},
+		},
+		{
+			Name:        "counter-service",
+			Prefix:      "/counter",
+			UpstreamURL: counterServiceURL + "/count",
+			HealthPath:  "/health",
+			Mode:        RouteModeEnvelope,
+		},
+	}
+}
+
+// loadRoutesFromFile reads a JSON array of Route entries from path.
+func loadRoutesFromFile(path string) ([]Route, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read routes file: %w", err)
+	}
+
+	var routes []Route
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("parse routes file: %w", err)
+	}
+	return routes, nil
+}
+
+// watchForReload reloads the route registry from routesFile whenever the
+// process receives SIGHUP, so operators can add services without a restart.
+func watchForReload(routesFile string) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+
+	go func() {
+		for range sigCh {
+			routes, err := loadRoutesFromFile(routesFile)
+			if err != nil {
+				logger.Error("routes reload failed", "file", routesFile, "error", err)
+				continue
+			}
+			routeRegistry.Reload(routes)
+			logger.Info("routes reloaded", "file", routesFile, "count", len(routes))
+		}
+	}()
+}
+
+// handleProxy is the catch-all handler: it matches the request path against
+// the route registry and proxies to the matched upstream with the route's
+// prefix stripped.
+func handleProxy(w http.ResponseWriter, r *http.Request) {
+	route, ok := routeRegistry.Match(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !route.allowsMethod(r.Method) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if useEnvelopeMode(route, r) {
+		rest := strings.TrimPrefix(r.URL.Path, route.Prefix)
+		targetURL := strings.TrimSuffix(route.UpstreamURL, "/") + rest
+		proxyRequest(w, r, targetURL, route.Name)
+		return
+	}
+
+	cb := breakerFor(route.Name)
+	if !cb.Allow() {
+		recordUpstreamError(route.Name, "circuit_open")
+		recordRequest(route.Name, r.Method, http.StatusServiceUnavailable)
+		respondWithStatus(w, route.Name, "circuit open", http.StatusServiceUnavailable, 0)
+		return
+	}
+
+	start := time.Now()
+	atomic.AddInt64(&inFlightRequests, 1)
+	defer atomic.AddInt64(&inFlightRequests, -1)
+
+	reverseProxyFor(route).ServeHTTP(w, r)
+	observeDuration(route.Name, time.Since(start).Seconds())
+}
+
+// useEnvelopeMode reports whether route should use the legacy buffered
+// JSON-envelope behavior instead of transparent streaming: either the route
+// is configured for it, or the caller opted in with ?wrap=true.
+func useEnvelopeMode(route Route, r *http.Request) bool {
+	if effectiveMode(route) == RouteModeEnvelope {
+		return true
+	}
+	return r.URL.Query().Get("wrap") == "true"
+}
+
+var (
+	reverseProxiesMu sync.Mutex
+	reverseProxies   = map[string]*httputil.ReverseProxy{}
+)
+
+// reverseProxyFor returns the cached httputil.ReverseProxy for route,
+// building one on first use (or after its upstream/prefix changes, e.g.
+// across a SIGHUP reload).
+func reverseProxyFor(route Route) *httputil.ReverseProxy {
+	key := route.Name + "|" + route.Prefix + "|" + route.UpstreamURL
+
+	reverseProxiesMu.Lock()
+	defer reverseProxiesMu.Unlock()
+
+	if p, ok := reverseProxies[key]; ok {
+		return p
+	}
+	p := buildReverseProxy(route)
+	reverseProxies[key] = p
+	return p
+}
+
+// buildReverseProxy returns a streaming reverse proxy for route: it strips
+// route.Prefix from the forwarded path, preserves the query string, and
+// feeds successes/failures into that service's circuit breaker and metrics
+// without buffering the body into memory.
+func buildReverseProxy(route Route) *httputil.ReverseProxy {
+	upstream, err := url.Parse(route.UpstreamURL)
+	if err != nil {
+		logger.Error("invalid route upstream URL", "service", route.Name, "upstreamURL", route.UpstreamURL, "error", err)
+		upstream = &url.URL{}
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+
+	baseDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		req.URL.Path = strings.TrimPrefix(req.URL.Path, route.Prefix)
+		baseDirector(req)
+	}
+
+	cb := breakerFor(route.Name)
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			cb.RecordFailure()
+			recordUpstreamError(route.Name, "bad_status")
+		} else {
+			cb.RecordSuccess()
+		}
+		recordRequest(route.Name, resp.Request.Method, resp.StatusCode)
+		return nil
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		cb.RecordFailure()
+		recordUpstreamError(route.Name, "unreachable")
+		recordRequest(route.Name, r.Method, http.StatusBadGateway)
+		logger.Error("reverse proxy error", "request_id", requestIDFromContext(r.Context()), "service", route.Name, "error", err)
+		respondWithError(w, route.Name, fmt.Sprintf("Failed to reach service: %v", err), 0)
+	}
+
+	return proxy
+}
+
+// handleRoutesAdmin lists the currently active routes.
+func handleRoutesAdmin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(routeRegistry.Snapshot())
+}
+
+const defaultAggregateTimeout = 2 * time.Second
+
+// fetchAggregateRoute makes a single, non-retried GET against route's
+// UpstreamURL and returns its decoded body (or the raw body as a string
+// when it isn't JSON).
+func fetchAggregateRoute(ctx context.Context, route Route) (interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, route.UpstreamURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstreamUnreachable, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstreamUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUpstreamUnreachable, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%w: status %d", ErrUpstreamBadStatus, resp.StatusCode)
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return string(body), nil
+	}
+	return data, nil
+}
+
+// dedupeRoutesByName returns one Route per distinct Name, keeping the first
+// occurrence. A service like counter-service can register multiple Route
+// entries for different path prefixes (e.g. "/counter" and
+// "/counter/increment"); fanning out to all of them would hit the same
+// service more than once and race the results under one map key.
+func dedupeRoutesByName(routes []Route) []Route {
+	seen := make(map[string]bool, len(routes))
+	deduped := make([]Route, 0, len(routes))
+	for _, route := range routes {
+		if seen[route.Name] {
+			continue
+		}
+		seen[route.Name] = true
+		deduped = append(deduped, route)
+	}
+	return deduped
+}
+
+// handleAggregate fans out a GET to every distinct registered upstream, using
+// only routes that allow GET, concurrently and merges the results keyed by service name. Individual
+// upstream failures don't fail the whole request: they're reported under
+// "errors" while the rest of the services still populate "data".
+func handleAggregate(w http.ResponseWriter, r *http.Request) {
+	timeout := defaultAggregateTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			timeout = d
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	var getRoutes []Route
+	for _, route := range routeRegistry.Snapshot() {
+		if route.allowsMethod(http.MethodGet) {
+			getRoutes = append(getRoutes, route)
+		}
+	}
+	routes := dedupeRoutesByName(getRoutes)
+
+	type aggregateResult struct {
+		name string
+		data interface{}
+		err  error
+	}
+
+	resultCh := make(chan aggregateResult, len(routes))
+
+	var wg sync.WaitGroup
+	for _, route := range routes {
+		wg.Add(1)
+		go func(route Route) {
+			defer wg.Done()
+			data, err := fetchAggregateRoute(ctx, route)
+			resultCh <- aggregateResult{name: route.Name, data: data, err: err}
+		}(route)
+	}
+
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	data := make(map[string]interface{}, len(routes))
+	errs := make(map[string]string)
+	for res := range resultCh {
+		if res.err != nil {
+			errs[res.name] = res.err.Error()
+			continue
+		}
+		data[res.name] = res.data
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":   data,
+		"errors": errs,
+	})
 }
 
 func main() {
@@ -31,83 +842,271 @@ func main() {
 		counterServiceURL = "http://counter-service"
 	}
 
-	http.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
-		proxyRequest(w, r, helloServiceURL, "hello-service")
-	})
-
-	http.HandleFunc("/counter", func(w http.ResponseWriter, r *http.Request) {
-		proxyRequest(w, r, counterServiceURL+"/count", "counter-service")
-	})
-
-	http.HandleFunc("/counter/increment", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
+	routesFile := os.Getenv("GATEWAY_ROUTES_FILE")
+	if routesFile != "" {
+		routes, err := loadRoutesFromFile(routesFile)
+		if err != nil {
+			logger.Error("failed to load routes file", "file", routesFile, "error", err)
+			os.Exit(1)
 		}
-		proxyRequest(w, r, counterServiceURL+"/increment", "counter-service")
-	})
+		routeRegistry = newRouteRegistry(routes)
+		watchForReload(routesFile)
+	} else {
+		routeRegistry = newRouteRegistry(defaultRoutes(helloServiceURL, counterServiceURL))
+	}
 
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/health", withRequestID(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
-	})
+	}))
+
+	http.HandleFunc("/admin/breakers", withRequestID(handleBreakersAdmin))
+	http.HandleFunc("/admin/routes", withRequestID(handleRoutesAdmin))
+	http.HandleFunc("/aggregate", withRequestID(handleAggregate))
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/", withRequestID(handleProxy))
 
-	log.Printf("API Gateway starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	logger.Info("API Gateway starting", "port", port)
+	if err := http.ListenAndServe(":"+port, nil); err != nil {
+		logger.Error("server exited", "error", err)
+		os.Exit(1)
+	}
 }
 
-func proxyRequest(w http.ResponseWriter, r *http.Request, targetURL string, serviceName string) {
+// isIdempotent reports whether method is safe to retry by default, or r
+// carries an explicit opt-in for non-idempotent retries.
+func isIdempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	default:
+		return r.Header.Get("X-Retry-Idempotent") == "true"
+	}
+}
+
+// handleBreakersAdmin reports the current state of every known circuit
+// breaker, keyed by service name.
+func handleBreakersAdmin(w http.ResponseWriter, r *http.Request) {
+	breakersMu.Lock()
+	snapshot := make(map[string]*CircuitBreaker, len(breakers))
+	for name, cb := range breakers {
+		snapshot[name] = cb
+	}
+	breakersMu.Unlock()
+
+	out := make(map[string]CircuitState, len(snapshot))
+	for name, cb := range snapshot {
+		out[name] = cb.State()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// Sentinel errors returned by callUpstream. Middleware and callers can use
+// errors.Is to distinguish a dead upstream from one that merely answered
+// with an unexpected status or body.
+var (
+	ErrUpstreamUnreachable = errors.New("upstream unreachable")
+	ErrUpstreamBadStatus   = errors.New("upstream returned a non-success status")
+	ErrUpstreamBadJSON     = errors.New("upstream response body was not valid JSON")
+)
+
+// forwardedHeaders are copied verbatim from the inbound request onto the
+// upstream request so auth and tracing survive the hop.
+var forwardedHeaders = []string{
+	"Authorization",
+	"X-Request-ID",
+	"Traceparent",
+	"Tracestate",
+	"X-B3-Traceid",
+	"X-B3-Spanid",
+	"X-B3-Parentspanid",
+	"X-B3-Sampled",
+}
+
+// upstreamResult is the outcome of a successful round trip to an upstream:
+// the response was read in full, even if its status or body shape was not
+// what we expected.
+type upstreamResult struct {
+	StatusCode int
+	Data       interface{} // decoded JSON, or the raw body as a string when it isn't JSON
+	Attempts   int
+	SoftErr    error // ErrUpstreamBadStatus / ErrUpstreamBadJSON; non-fatal, for logging
+}
+
+// buildTargetURL appends the inbound request's query string to targetURL,
+// which is otherwise dropped today.
+func buildTargetURL(targetURL string, r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return targetURL
+	}
+	return targetURL + "?" + r.URL.RawQuery
+}
+
+// callUpstream proxies r to targetURL, retrying per retryPolicy, and
+// returns a fatal error only when the upstream could never be reached
+// (dial failure, broken connection, unreadable body). The returned attempts
+// count reflects the number of tries actually made, whether callUpstream
+// succeeds or exhausts its retries.
+func callUpstream(r *http.Request, targetURL string, bodyBytes []byte, serviceName string, cb *CircuitBreaker) (*upstreamResult, int, error) {
 	client := &http.Client{}
-	
-	var req *http.Request
-	var err error
-	
-	if r.Method == http.MethodPost {
-		req, err = http.NewRequest(http.MethodPost, targetURL, r.Body)
-	} else {
-		req, err = http.NewRequest(http.MethodGet, targetURL, nil)
+	fullURL := buildTargetURL(targetURL, r)
+
+	retryable := isIdempotent(r)
+	maxAttempts := retryPolicy.MaxAttempts
+	if !retryable {
+		maxAttempts = 1
 	}
-	
-	if err != nil {
-		respondWithError(w, serviceName, fmt.Sprintf("Failed to create request: %v", err))
-		return
+
+	var lastErr error
+	var resp *http.Response
+	finalAttempt := maxAttempts
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		finalAttempt = attempt
+		var req *http.Request
+		var err error
+
+		if r.Method == http.MethodPost {
+			req, err = http.NewRequest(http.MethodPost, fullURL, bytes.NewReader(bodyBytes))
+		} else {
+			req, err = http.NewRequest(http.MethodGet, fullURL, nil)
+		}
+
+		if err != nil {
+			return nil, finalAttempt, fmt.Errorf("%w: failed to create request: %v", ErrUpstreamUnreachable, err)
+		}
+
+		for _, h := range forwardedHeaders {
+			if v := r.Header.Get(h); v != "" {
+				req.Header.Set(h, v)
+			}
+		}
+
+		resp, err = client.Do(req)
+		if err != nil {
+			lastErr = err
+			logger.Warn("proxy attempt failed", "service", serviceName, "attempt", attempt, "max_attempts", maxAttempts, "error", err)
+		} else if retryable && retryPolicy.RetryableStatus[resp.StatusCode] && attempt < maxAttempts {
+			lastErr = fmt.Errorf("upstream returned status %d", resp.StatusCode)
+			logger.Warn("proxy attempt got retryable status", "service", serviceName, "attempt", attempt, "max_attempts", maxAttempts, "status", resp.StatusCode)
+			resp.Body.Close()
+		} else {
+			lastErr = nil
+			break
+		}
+
+		if attempt < maxAttempts {
+			time.Sleep(backoffDelay(retryPolicy, attempt-1))
+		}
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		respondWithError(w, serviceName, fmt.Sprintf("Failed to reach service: %v", err))
-		return
+	if lastErr != nil {
+		cb.RecordFailure()
+		return nil, finalAttempt, fmt.Errorf("%w: %v", ErrUpstreamUnreachable, lastErr)
 	}
+	cb.RecordSuccess()
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		respondWithError(w, serviceName, fmt.Sprintf("Failed to read response: %v", err))
-		return
+		return nil, finalAttempt, fmt.Errorf("%w: failed to read response: %v", ErrUpstreamUnreachable, err)
+	}
+
+	result := &upstreamResult{StatusCode: resp.StatusCode, Attempts: finalAttempt}
+	if resp.StatusCode >= 400 {
+		result.SoftErr = ErrUpstreamBadStatus
 	}
 
 	var data interface{}
 	if err := json.Unmarshal(body, &data); err != nil {
-		respondWithError(w, serviceName, fmt.Sprintf("Failed to parse response: %v", err))
+		result.Data = string(body)
+		if result.SoftErr == nil {
+			result.SoftErr = ErrUpstreamBadJSON
+		}
+	} else {
+		result.Data = data
+	}
+
+	return result, finalAttempt, nil
+}
+
+func proxyRequest(w http.ResponseWriter, r *http.Request, targetURL string, serviceName string) {
+	start := time.Now()
+	requestID := requestIDFromContext(r.Context())
+
+	atomic.AddInt64(&inFlightRequests, 1)
+	defer atomic.AddInt64(&inFlightRequests, -1)
+
+	cb := breakerFor(serviceName)
+	if !cb.Allow() {
+		recordUpstreamError(serviceName, "circuit_open")
+		recordRequest(serviceName, r.Method, http.StatusServiceUnavailable)
+		logger.Warn("proxy request rejected", "request_id", requestID, "service", serviceName, "method", r.Method, "status", http.StatusServiceUnavailable, "error", "circuit open")
+		respondWithStatus(w, serviceName, "circuit open", http.StatusServiceUnavailable, 0)
+		return
+	}
+
+	var bodyBytes []byte
+	if r.Body != nil {
+		var err error
+		bodyBytes, err = io.ReadAll(r.Body)
+		if err != nil {
+			respondWithError(w, serviceName, fmt.Sprintf("Failed to read request body: %v", err), 0)
+			return
+		}
+	}
+
+	result, attempts, err := callUpstream(r, targetURL, bodyBytes, serviceName, cb)
+	if err != nil {
+		recordUpstreamError(serviceName, "unreachable")
+		recordRequest(serviceName, r.Method, http.StatusBadGateway)
+		observeDuration(serviceName, time.Since(start).Seconds())
+		logger.Error("proxy request failed", "request_id", requestID, "service", serviceName, "method", r.Method, "status", http.StatusBadGateway, "duration_ms", time.Since(start).Milliseconds(), "error", err)
+		respondWithError(w, serviceName, fmt.Sprintf("Failed to reach service: %v", err), attempts)
 		return
 	}
 
+	if result.SoftErr != nil {
+		kind := "bad_status"
+		if errors.Is(result.SoftErr, ErrUpstreamBadJSON) {
+			kind = "bad_json"
+		}
+		recordUpstreamError(serviceName, kind)
+		logger.Warn("proxy upstream soft error", "request_id", requestID, "service", serviceName, "status", result.StatusCode, "error", result.SoftErr)
+	}
+
+	recordRequest(serviceName, r.Method, result.StatusCode)
+	observeDuration(serviceName, time.Since(start).Seconds())
+
 	response := Response{
-		Service: serviceName,
-		Data:    data,
+		Service:  serviceName,
+		Data:     result.Data,
+		Attempts: result.Attempts,
 	}
 
+	body, _ := json.Marshal(response)
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.StatusCode)
-	json.NewEncoder(w).Encode(response)
+	w.WriteHeader(result.StatusCode)
+	n, _ := w.Write(body)
+
+	logger.Info("proxy request", "request_id", requestID, "service", serviceName, "method", r.Method, "status", result.StatusCode, "duration_ms", time.Since(start).Milliseconds(), "attempts", result.Attempts, "bytes", n)
+}
+
+func respondWithError(w http.ResponseWriter, serviceName string, errorMsg string, attempts int) {
+	respondWithStatus(w, serviceName, errorMsg, http.StatusBadGateway, attempts)
 }
 
-func respondWithError(w http.ResponseWriter, serviceName string, errorMsg string) {
+// respondWithStatus writes a Response with an explicit HTTP status, used for
+// cases like a tripped circuit breaker that aren't a generic bad gateway.
+func respondWithStatus(w http.ResponseWriter, serviceName string, errorMsg string, status int, attempts int) {
 	response := Response{
-		Service: serviceName,
-		Error:   errorMsg,
+		Service:  serviceName,
+		Error:    errorMsg,
+		Attempts: attempts,
 	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusBadGateway)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(response)
 }